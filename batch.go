@@ -0,0 +1,59 @@
+package logger
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+const (
+	// The maximum number of log events in a PutLogEvents batch is 10,000.
+	MaxBatchCount = 10000
+
+	// The maximum batch size is 1,048,576 bytes, calculated as the sum of
+	// all messages in UTF-8, plus 26 bytes of overhead per log event.
+	MaxBatchSize = 1 << 20
+
+	// A batch of log events cannot span more than 24 hours between its
+	// earliest and latest timestamp.
+	MaxBatchSpan = 24 * time.Hour
+)
+
+// eventBatch accumulates InputLogEvents for a single PutLogEvents call.
+type eventBatch struct {
+	events []*cloudwatchlogs.InputLogEvent
+	bytes  int
+	start  int64 // timestamp (ms) of the first event added to the batch
+}
+
+func (b *eventBatch) add(e *cloudwatchlogs.InputLogEvent) bool {
+	if b.full(messageSize(e), *e.Timestamp) {
+		return false
+	}
+	if len(b.events) == 0 {
+		b.start = *e.Timestamp
+	}
+	b.events = append(b.events, e)
+	b.bytes += messageSize(e)
+	return true
+}
+
+func (b *eventBatch) full(size int, timestamp int64) bool {
+	if len(b.events) >= MaxBatchCount || b.bytes+size > MaxBatchSize {
+		return true
+	}
+	if len(b.events) > 0 && time.Duration(timestamp-b.start)*time.Millisecond > MaxBatchSpan {
+		return true
+	}
+	return false
+}
+
+func (b *eventBatch) reset() {
+	b.events = nil
+	b.bytes = 0
+	b.start = 0
+}
+
+func messageSize(e *cloudwatchlogs.InputLogEvent) int {
+	return len(*e.Message) + 26
+}
@@ -0,0 +1,41 @@
+package logger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+func TestEventBatchRejectsEventsThatExceedMaxBatchSpan(t *testing.T) {
+	b := &eventBatch{}
+
+	start := time.Now()
+	first := &cloudwatchlogs.InputLogEvent{
+		Timestamp: aws.Int64(start.UnixNano() / int64(time.Millisecond)),
+		Message:   aws.String("first"),
+	}
+	if !b.add(first) {
+		t.Fatal("expected the first event to be added")
+	}
+
+	tooLate := &cloudwatchlogs.InputLogEvent{
+		Timestamp: aws.Int64(start.Add(MaxBatchSpan + time.Second).UnixNano() / int64(time.Millisecond)),
+		Message:   aws.String("too late"),
+	}
+	if b.add(tooLate) {
+		t.Fatal("expected an event past MaxBatchSpan from the batch start to be rejected")
+	}
+	if len(b.events) != 1 {
+		t.Fatalf("expected the rejected event not to be added, got %d events", len(b.events))
+	}
+
+	withinSpan := &cloudwatchlogs.InputLogEvent{
+		Timestamp: aws.Int64(start.Add(MaxBatchSpan - time.Second).UnixNano() / int64(time.Millisecond)),
+		Message:   aws.String("within span"),
+	}
+	if !b.add(withinSpan) {
+		t.Fatal("expected an event within MaxBatchSpan of the batch start to be added")
+	}
+}
@@ -0,0 +1,46 @@
+package logger
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// ensureLogGroupAndStream optionally creates the log group and stream up
+// front and applies a retention policy to the group; safe to run on every
+// startup since ResourceAlreadyExistsException is treated as success.
+func (l *Logger) ensureLogGroupAndStream(createGroup, createStream bool, retentionDays int64) error {
+
+	if createGroup {
+		if _, err := l.Service.CreateLogGroup(&cloudwatchlogs.CreateLogGroupInput{
+			LogGroupName: l.group,
+		}); err != nil && !isResourceAlreadyExists(err) {
+			return err
+		}
+
+		if retentionDays > 0 {
+			if _, err := l.Service.PutRetentionPolicy(&cloudwatchlogs.PutRetentionPolicyInput{
+				LogGroupName:    l.group,
+				RetentionInDays: aws.Int64(retentionDays),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if createStream {
+		if _, err := l.Service.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
+			LogGroupName:  l.group,
+			LogStreamName: l.stream,
+		}); err != nil && !isResourceAlreadyExists(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isResourceAlreadyExists(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == "ResourceAlreadyExistsException"
+}
@@ -9,14 +9,18 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
 )
 
 type (
@@ -24,7 +28,7 @@ type (
 		w io.Writer
 
 		// Service exposed for direct actions
-		Service *cloudwatchlogs.CloudWatchLogs
+		Service cloudwatchlogsiface.CloudWatchLogsAPI
 
 		// logging tokens
 		group         *string
@@ -32,8 +36,10 @@ type (
 		sequenceToken *string
 
 		// internal
-		sw   *ScannerWriter
-		done chan struct{}
+		sw            *ScannerWriter
+		queue         *messageQueue
+		flushInterval time.Duration
+		closed        chan struct{}
 	}
 
 	LogMessage struct {
@@ -45,7 +51,9 @@ type (
 )
 
 const (
-	MaxMessageLength = 32 << 10
+	// CloudWatch Logs accepts events up to 262,144 bytes, minus 26 bytes of
+	// per-event overhead.
+	MaxMessageLength = 262144 - 26
 )
 
 var (
@@ -53,24 +61,88 @@ var (
 	// not available.  At which point the batch is dumped to stderr
 	MaxRetryTime = time.Hour
 
-	// the buffer length of the log event channel
-	EventLogBufferLength = 64 << 10
-
-	// this occurs when the buffered channel receiving log writes blocks
-	ErrStreamBackedUp = errors.New("stream backed up")
+	// how long Close waits for the consumer goroutine to finish its final
+	// flush, as a multiple of flushInterval, before giving up
+	CloseTimeoutMultiplier = 2
 )
 
-func NewLogger(sess *session.Session, endpoint, group, stream, level string, flushInterval time.Duration, image, instance string) (*Logger, error) {
+func NewLogger(sess *session.Session, endpoint, group, stream, level string, flushInterval time.Duration, image, instance, datetimeFormat, multilinePattern string, createGroup, createStream bool, retentionDays int64) (*Logger, error) {
 	config := aws.NewConfig()
 	config.Endpoint = &endpoint
+
+	return newLogger(cloudwatchlogs.New(sess, config), group, stream, level, flushInterval, image, instance, datetimeFormat, multilinePattern, createGroup, createStream, retentionDays)
+}
+
+// newLogger builds a Logger against an already-constructed CloudWatch Logs
+// client, so tests can substitute a fake one.
+func newLogger(service cloudwatchlogsiface.CloudWatchLogsAPI, group, stream, level string, flushInterval time.Duration, image, instance, datetimeFormat, multilinePattern string, createGroup, createStream bool, retentionDays int64) (*Logger, error) {
 	l := &Logger{
-		Service: cloudwatchlogs.New(sess, config),
-		group:   &group,
-		stream:  &stream,
-		done:    make(chan struct{}),
+		Service:       service,
+		group:         &group,
+		stream:        &stream,
+		queue:         newMessageQueue(),
+		flushInterval: flushInterval,
+		closed:        make(chan struct{}),
+	}
+
+	if err := l.ensureLogGroupAndStream(createGroup, createStream, retentionDays); err != nil {
+		return nil, err
+	}
+
+	var isRecordStart func(line []byte) bool
+	switch {
+	case multilinePattern != "":
+		isRecordStart = NewPatternRecordStart(regexp.MustCompile(multilinePattern))
+	case datetimeFormat != "":
+		isRecordStart = NewDatetimeRecordStart(datetimeFormat)
 	}
 
-	events := make(chan *cloudwatchlogs.InputLogEvent, EventLogBufferLength)
+	splitFunc := bufio.ScanLines
+	var blockIdle func() time.Duration
+	if isRecordStart != nil {
+		splitFunc, blockIdle = NewMultilineSplitFunc(isRecordStart)
+	}
+
+	// marshaledSize is what actually counts against MaxMessageLength: the
+	// JSON envelope plus escaping can inflate a chunk well past its raw
+	// byte length, so the split below measures this instead of len(chunk).
+	marshaledSize := func(chunk string) int {
+		m := &LogMessage{Instance: &instance, Image: &image, Level: &level, Message: &chunk}
+		b, _ := json.Marshal(m)
+		return len(b)
+	}
+
+	l.sw = NewScannerWriter(splitFunc, MaxMessageLength, func(token []byte) error {
+		// a single token (line, or multiline block) may exceed the per-event
+		// limit; split it at UTF-8 rune boundaries rather than drop or error
+		timestamp := aws.Int64(time.Now().UnixNano() / int64(time.Millisecond))
+
+		for _, chunk := range splitMessage(string(token), MaxMessageLength, marshaledSize) {
+			message := chunk
+
+			m := &LogMessage{
+				Instance : &instance,
+				Image    : &image,
+				Level    : &level,
+				Message  : &message}
+
+			json, _ := json.Marshal(m)
+			s := string(json)
+
+			e := &cloudwatchlogs.InputLogEvent{
+				Timestamp: timestamp,
+				Message:   aws.String(s),
+			}
+
+			if !l.queue.Enqueue(e) {
+				// the logger is already closing, drop to stderr
+				fmt.Fprintf(os.Stderr, "%#v\n", e)
+			}
+		}
+
+		return nil
+
+	})
 
 	go func() {
 
@@ -80,6 +152,8 @@ func NewLogger(sess *session.Session, endpoint, group, stream, level string, flu
 		var logEvents []*cloudwatchlogs.InputLogEvent
 
 		for {
+			done := false
+
 			func() {
 				defer func() {
 					if e := recover(); e != nil {
@@ -87,104 +161,132 @@ func NewLogger(sess *session.Session, endpoint, group, stream, level string, flu
 					}
 				}()
 				select {
-				case e := <-events:
-					logEvents = append(logEvents, e)
+				case <-l.queue.Wake():
+					items, closed := l.queue.Drain()
+					logEvents = append(logEvents, items...)
+					if closed {
+						if isRecordStart != nil {
+							l.sw.Flush()
+						}
+						l.flush(logEvents)
+						logEvents = nil
+						done = true
+					}
 				case <-flushTime.C:
+					if isRecordStart != nil && blockIdle() >= flushInterval {
+						// the block hasn't seen a new line in a full
+						// flushInterval; treat it as stalled (e.g. a stack
+						// trace that never resumes) rather than holding it
+						// forever, but leave a still-growing block alone
+						l.sw.Flush()
+					}
 					if len(logEvents) > 0 {
 						l.flush(logEvents)
 						logEvents = nil
 					}
-				case <-l.done:
-					for {
-						select {
-						case e := <-events:
-							logEvents = append(logEvents, e)
-						default:
-							l.flush(logEvents)
-							l.done <- struct{}{}
-							close(l.done)
-							runtime.Goexit()
-						}
-					}
 				}
 			}()
+
+			if done {
+				close(l.closed)
+				return
+			}
 		}
 
 	}()
 
-	l.sw = NewScannerWriter(bufio.ScanLines, MaxMessageLength, func(token []byte) error {
-		message := string(token)
-
-		m := &LogMessage{
-			Instance : &instance,
-			Image    : &image,
-			Level    : &level,
-			Message  : &message}
-
-		json, _ := json.Marshal(m)
-		s := string(json)
-
-		e := &cloudwatchlogs.InputLogEvent{
-			Timestamp: aws.Int64(time.Now().UnixNano() / int64(time.Millisecond)),
-			Message:   aws.String(s),
-		}
+	return l, nil
 
-		select {
-		case events <- e:
-		default:
-			// we're backed up, drop to stderr
-			fmt.Fprintf(os.Stderr, "%#v\n", e)
-			// this error will never be caught because
-			// no one ever checks the return values of log.* calls
-			// but return it anyway to be a good citizen
-			return ErrStreamBackedUp
-		}
+}
 
-		return nil
+// splitMessage breaks s into chunks whose marshaled size (as reported by
+// size, e.g. after wrapping in the CloudWatch envelope and JSON-escaping)
+// fits within maxBytes, never cutting a multi-byte UTF-8 rune in half.
+func splitMessage(s string, maxBytes int, size func(string) int) []string {
+	if size(s) <= maxBytes {
+		return []string{s}
+	}
 
-	})
+	var chunks []string
+	for size(s) > maxBytes {
+		cut := splitPoint(s, maxBytes, size)
+		chunks = append(chunks, s[:cut])
+		s = s[cut:]
+	}
+	if len(s) > 0 {
+		chunks = append(chunks, s)
+	}
 
-	return l, nil
+	return chunks
+}
 
+// splitPoint finds the longest UTF-8-safe prefix of s whose marshaled size
+// is within maxBytes, via binary search over byte offsets.
+func splitPoint(s string, maxBytes int, size func(string) int) int {
+	lo, hi, cut := 1, len(s), 1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		for mid > 0 && !utf8.RuneStart(s[mid]) {
+			mid--
+		}
+		if mid == 0 {
+			break
+		}
+		if size(s[:mid]) <= maxBytes {
+			cut = mid
+			lo = mid + 1
+		} else {
+			hi = mid - 1
+		}
+	}
+	return cut
 }
 
-func eventLength(e *cloudwatchlogs.InputLogEvent) int {
-	return len(*e.Message) + 26 // padding per spec
+// dropStaleEvents filters out events CloudWatch would reject outright:
+// anything older than 14 days or more than 2 hours in the future.
+func dropStaleEvents(logEvents []*cloudwatchlogs.InputLogEvent) []*cloudwatchlogs.InputLogEvent {
+	now := time.Now()
+	oldest := now.Add(-14 * 24 * time.Hour).UnixNano() / int64(time.Millisecond)
+	newest := now.Add(2 * time.Hour).UnixNano() / int64(time.Millisecond)
+
+	filtered := logEvents[:0]
+	for _, e := range logEvents {
+		if *e.Timestamp < oldest || *e.Timestamp > newest {
+			fmt.Fprintf(os.Stderr, "dropping log event outside CloudWatch's accepted time range: %s\n",
+				time.Unix(0, *e.Timestamp*int64(time.Millisecond)))
+			continue
+		}
+		filtered = append(filtered, e)
+	}
+
+	return filtered
 }
 
 func (l *Logger) flush(logEvents []*cloudwatchlogs.InputLogEvent) {
 
+	// The log events in a batch must be sorted chronologically by timestamp.
+	sort.SliceStable(logEvents, func(i, j int) bool {
+		return *logEvents[i].Timestamp < *logEvents[j].Timestamp
+	})
+
+	logEvents = dropStaleEvents(logEvents)
+
 	// The maximum rate of a PutLogEvents request is 5 requests per second per log stream.
 	rate := NewRateLimiter(5, time.Second)
 	defer rate.Close()
 
 	for len(logEvents) > 0 && rate.Ready() {
 
-		var (
-			batchSize int
-			batch     []*cloudwatchlogs.InputLogEvent
-		)
-
 		// None of the log events in the batch can be more than 2 hours in the future.
 		// None of the log events in the batch can be older than 14 days or the retention period of the log group.
 		// The log events in the batch must be in chronological ordered by their timestamp.
-		const (
-			// The maximum batch size is 1,048,576 bytes, and this size is calculated as the sum of all messages in UTF-8, plus 26 bytes for each log entry.
-			MaxBatchSize = 1 << 20
-			// The maximum number of log events in a batch is 10,000.
-			MaxBatchCount = 10000
-		)
-
-		for batchSize < MaxBatchSize &&
-			len(batch) < MaxBatchCount &&
-			len(logEvents) > 0 {
-			batch = append(batch, logEvents[0])
-			batchSize += eventLength(logEvents[0])
+		batch := &eventBatch{}
+		for len(logEvents) > 0 && batch.add(logEvents[0]) {
 			logEvents = logEvents[1:]
 		}
 
 		input := &cloudwatchlogs.PutLogEventsInput{
-			LogEvents:     batch,
+			LogEvents:     batch.events,
 			LogGroupName:  l.group,
 			LogStreamName: l.stream,
 			SequenceToken: l.sequenceToken,
@@ -255,7 +357,7 @@ func (l *Logger) flush(logEvents []*cloudwatchlogs.InputLogEvent) {
 			return nil, false
 
 		}); err != nil {
-			failBatch(batch)
+			failBatch(batch.events)
 		}
 
 	}
@@ -333,8 +435,20 @@ func (l *Logger) WriteError(err error) error {
 	})
 }
 
+// Close stops accepting new log events, flushes whatever is queued, and
+// waits for that final flush to complete. It never blocks indefinitely: if
+// the consumer goroutine hasn't finished within CloseTimeoutMultiplier *
+// flushInterval, Close gives up and returns anyway.
 func (l *Logger) Close() error {
-	l.done <- struct{}{}
-	<-l.done
+	// emit any still-open block (or unterminated line) while the queue
+	// still accepts it; once closed, Enqueue refuses new events
+	l.sw.Flush()
+	l.queue.Close()
+
+	select {
+	case <-l.closed:
+	case <-time.After(time.Duration(CloseTimeoutMultiplier) * l.flushInterval):
+	}
+
 	return nil
 }
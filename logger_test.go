@@ -0,0 +1,176 @@
+package logger
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+)
+
+// fakeCloudWatchLogs is a minimal cloudwatchlogsiface.CloudWatchLogsAPI that
+// records every PutLogEvents call; all other methods panic if called.
+type fakeCloudWatchLogs struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+
+	mu     sync.Mutex
+	events []*cloudwatchlogs.InputLogEvent
+}
+
+func (f *fakeCloudWatchLogs) PutLogEvents(in *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.events = append(f.events, in.LogEvents...)
+	return &cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: aws.String("0")}, nil
+}
+
+func (f *fakeCloudWatchLogs) all() []*cloudwatchlogs.InputLogEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	events := make([]*cloudwatchlogs.InputLogEvent, len(f.events))
+	copy(events, f.events)
+	return events
+}
+
+func TestWriteSplitsOversizedLineAtMarshaledSize(t *testing.T) {
+	fake := &fakeCloudWatchLogs{}
+	l, err := newLogger(fake, "group", "stream", "INFO", time.Hour, "image", "instance", "", "", false, false, 0)
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+
+	// quote- and backslash-dense content inflates heavily once JSON-escaped,
+	// so a naive raw-length split would produce events whose marshaled
+	// Message exceeds MaxMessageLength.
+	line := strings.Repeat(`"\`, 500000) + "\n"
+	if _, err := l.Write([]byte(line)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// read back via the fake client rather than l.queue, which the consumer
+	// goroutine started by newLogger is concurrently draining
+	events := fake.all()
+	if len(events) < 2 {
+		t.Fatalf("expected the oversized line to be split into multiple events, got %d", len(events))
+	}
+
+	for _, e := range events {
+		if !utf8.ValidString(*e.Message) {
+			t.Fatalf("event message is not valid UTF-8: %q", *e.Message)
+		}
+		if len(*e.Message) > MaxMessageLength {
+			t.Fatalf("marshaled event message is %d bytes, exceeds MaxMessageLength %d", len(*e.Message), MaxMessageLength)
+		}
+	}
+
+	for i := 1; i < len(events); i++ {
+		if *events[i].Timestamp != *events[0].Timestamp {
+			t.Fatalf("expected split events to share one timestamp, got %d and %d", *events[0].Timestamp, *events[i].Timestamp)
+		}
+	}
+}
+
+func TestFlushTimerDoesNotSplitActivelyGrowingMultilineBlock(t *testing.T) {
+	fake := &fakeCloudWatchLogs{}
+	flushInterval := 40 * time.Millisecond
+	layout := "15:04:05.000"
+	l, err := newLogger(fake, "group", "stream", "INFO", flushInterval, "image", "instance", layout, "", false, false, 0)
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+
+	// each append lands well under flushInterval of the last one, but the
+	// block as a whole takes longer than flushInterval to finish, so at
+	// least one flush tick is guaranteed to land mid-block.
+	l.Write([]byte(time.Now().Format(layout) + " first line\n"))
+	for i := 0; i < 4; i++ {
+		time.Sleep(flushInterval / 3)
+		l.Write([]byte("stack trace line\n"))
+	}
+	// each of these record-start lines closes out the previous block on
+	// its own; the third record's block is still open and Close flushes it
+	l.Write([]byte(time.Now().Format(layout) + " second record\n"))
+	l.Write([]byte(time.Now().Format(layout) + " third record\n"))
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if got := len(fake.all()); got != 3 {
+		t.Fatalf("expected the actively-written block to reach CloudWatch as a single event despite a flush tick landing mid-block, got %d events", got)
+	}
+}
+
+func TestCloseFlushesConcurrentWritesWithoutLoss(t *testing.T) {
+	fake := &fakeCloudWatchLogs{}
+	flushInterval := 20 * time.Millisecond
+	l, err := newLogger(fake, "group", "stream", "INFO", flushInterval, "image", "instance", "", "", false, false, 0)
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+
+	const writers = 20
+	const linesPerWriter = 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < linesPerWriter; j++ {
+				l.Write([]byte("line\n"))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	start := time.Now()
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Duration(CloseTimeoutMultiplier)*flushInterval+time.Second {
+		t.Fatalf("Close took %v, expected it to return within roughly %d*flushInterval", elapsed, CloseTimeoutMultiplier)
+	}
+
+	if got, want := len(fake.all()), writers*linesPerWriter; got != want {
+		t.Fatalf("expected no events lost, got %d events, want %d", got, want)
+	}
+}
+
+func TestFlushSortsAndDropsStaleEvents(t *testing.T) {
+	fake := &fakeCloudWatchLogs{}
+	l := &Logger{Service: fake, group: aws.String("group"), stream: aws.String("stream")}
+
+	ms := func(t time.Time) int64 { return t.UnixNano() / int64(time.Millisecond) }
+	now := time.Now()
+
+	events := []*cloudwatchlogs.InputLogEvent{
+		{Timestamp: aws.Int64(ms(now)), Message: aws.String("c")},
+		{Timestamp: aws.Int64(ms(now.Add(-15 * 24 * time.Hour))), Message: aws.String("too old")},
+		{Timestamp: aws.Int64(ms(now.Add(-time.Minute))), Message: aws.String("a")},
+		{Timestamp: aws.Int64(ms(now.Add(3 * time.Hour))), Message: aws.String("too far future")},
+		{Timestamp: aws.Int64(ms(now.Add(time.Second))), Message: aws.String("b")},
+	}
+
+	l.flush(events)
+
+	got := fake.all()
+	if len(got) != 3 {
+		t.Fatalf("expected stale/future events to be dropped, got %d events", len(got))
+	}
+	for i := 1; i < len(got); i++ {
+		if *got[i-1].Timestamp > *got[i].Timestamp {
+			t.Fatalf("events are not sorted chronologically: %v", got)
+		}
+	}
+}
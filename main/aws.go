@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/endpointcreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+var (
+	region              = flag.String("region", "", "AWS region to send logs to; defaults to AWS_REGION, then the EC2 instance's own region, then -default-region")
+	defaultRegion       = flag.String("default-region", "us-east-1", "region to fall back to when none can be discovered")
+	credentialsEndpoint = flag.String("credentials-endpoint", "", "relative URI of a container credentials endpoint (e.g. the ECS/Fargate task role endpoint); defaults to AWS_CONTAINER_CREDENTIALS_RELATIVE_URI")
+)
+
+// resolveRegion determines which AWS region to send logs to, in order: an
+// explicit -region flag, AWS_REGION, the EC2 instance's own region via IMDS,
+// and finally -default-region, so the agent runs unmodified on EC2 fleets
+// spread across regions without baking a region into the image.
+func resolveRegion(sess *session.Session) string {
+	if *region != "" {
+		return *region
+	}
+	if r := os.Getenv("AWS_REGION"); r != "" {
+		return r
+	}
+
+	meta := ec2metadata.New(sess, aws.NewConfig().WithHTTPClient(&http.Client{Timeout: 2 * time.Second}))
+	if r, err := meta.Region(); err == nil {
+		return r
+	}
+
+	return *defaultRegion
+}
+
+// resolveCredentials builds a credentials provider for the ECS/Fargate task
+// role when a container credentials endpoint is configured, or nil to leave
+// the SDK's default provider chain in place.
+func resolveCredentials(sess *session.Session) *credentials.Credentials {
+	endpoint := *credentialsEndpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI")
+	}
+	if endpoint == "" {
+		return nil
+	}
+
+	return credentials.NewCredentials(endpointcreds.NewProviderClient(
+		*sess.Config, sess.Handlers, "http://169.254.170.2"+endpoint))
+}
@@ -16,6 +16,11 @@ import (
 var (
 	stderr = flag.Bool("stderr", false, "true if this logs messages from stderr instead of stdout")
 	x = flag.Bool("X", false, "show internal logs")
+	datetimeFormat = flag.String("datetime-format", "", "Go reference-time layout (e.g. \"2006-01-02 15:04:05\") marking the start of a new log record; lines until the next match are grouped into one CloudWatch event")
+	multilinePattern = flag.String("multiline-pattern", "", "regular expression marking the start of a new log record; lines until the next match are grouped into one CloudWatch event")
+	createGroup = flag.Bool("create-group", true, "create the log group at startup if it doesn't already exist")
+	createStream = flag.Bool("create-stream", true, "create the log stream at startup if it doesn't already exist")
+	retentionDays = flag.Int64("retention-days", 0, "if set, number of days to retain logs in the group (one of AWS's allowed values, e.g. 1/3/5/7/14/30/.../3653); applied only when -create-group is true; 0 leaves retention unset")
 )
 
 func init() {
@@ -42,14 +47,20 @@ func main() {
 
 	endpoint := os.Getenv("BOXFUSE_CLOUDWATCHLOGS_ENDPOINT")
 	endpointMsg := "";
-	var awsSession *session.Session
+
+	base := session.New()
+	config := &aws.Config{Region: aws.String(resolveRegion(base))}
+
 	if endpoint != "" {
 		endpointMsg = " at " + endpoint;
-		awsSession = session.New(&aws.Config{Region: aws.String("us-east-1"), Credentials: credentials.NewStaticCredentials("dummy", "dummy", "")})
-	} else {
-		awsSession = session.New()
+		// a local/dummy endpoint doesn't validate credentials
+		config.Credentials = credentials.NewStaticCredentials("dummy", "dummy", "")
+	} else if creds := resolveCredentials(base); creds != nil {
+		config.Credentials = creds
 	}
 
+	awsSession := session.New(config)
+
 	level := "INFO"
 	if *stderr {
 		level = "ERROR"
@@ -57,7 +68,7 @@ func main() {
 
 	log.Println("Boxfuse CloudWatch Logs Agent " + version + " redirecting " + level + " logs for " + image + " to CloudWatch Logs" + endpointMsg + " (group: " + env + ", stream: " + app + ") ...")
 
-	logger1, err := logger.NewLogger(awsSession, endpoint, env, app, level, time.Second, image, instance, x)
+	logger1, err := logger.NewLogger(awsSession, endpoint, env, app, level, time.Second, image, instance, *datetimeFormat, *multilinePattern, *createGroup, *createStream, *retentionDays)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"bufio"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+// NewMultilineSplitFunc accumulates lines into a single token until a line
+// matching isRecordStart starts the next one, so e.g. a stack trace reaches
+// CloudWatch as one log event. The returned idle func reports how long it's
+// been since a line was last appended to the open block, so a caller can
+// force-flush a genuinely stalled block without splitting one that's still
+// being actively written.
+func NewMultilineSplitFunc(isRecordStart func(line []byte) bool) (splitFunc bufio.SplitFunc, idle func() time.Duration) {
+
+	var block []byte
+	var lastAppend int64 // unix nano; written under Write's lock, read from idle() without one
+
+	splitFunc = func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+
+		adv, line, err := bufio.ScanLines(data, atEOF)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if line == nil {
+			if atEOF && len(block) > 0 {
+				token, block = block, nil
+				return 0, token, nil
+			}
+			return 0, nil, nil
+		}
+
+		if len(block) > 0 && isRecordStart(line) {
+			token, block = block, append([]byte(nil), line...)
+			atomic.StoreInt64(&lastAppend, time.Now().UnixNano())
+			return adv, token, nil
+		}
+
+		if len(block) == 0 {
+			block = append([]byte(nil), line...)
+		} else {
+			block = append(block, '\n')
+			block = append(block, line...)
+		}
+		atomic.StoreInt64(&lastAppend, time.Now().UnixNano())
+
+		return adv, nil, nil
+	}
+
+	idle = func() time.Duration {
+		last := atomic.LoadInt64(&lastAppend)
+		if last == 0 {
+			return 0
+		}
+		return time.Since(time.Unix(0, last))
+	}
+
+	return splitFunc, idle
+}
+
+func NewDatetimeRecordStart(layout string) func(line []byte) bool {
+	return func(line []byte) bool {
+		if len(line) < len(layout) {
+			return false
+		}
+		_, err := time.Parse(layout, string(line[:len(layout)]))
+		return err == nil
+	}
+}
+
+func NewPatternRecordStart(pattern *regexp.Regexp) func(line []byte) bool {
+	return func(line []byte) bool {
+		return pattern.Match(line)
+	}
+}
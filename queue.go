@@ -0,0 +1,62 @@
+package logger
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// messageQueue is an unbounded, mutex-guarded queue of pending log events;
+// unlike a buffered channel, Enqueue never blocks.
+type messageQueue struct {
+	mu     sync.Mutex
+	items  []*cloudwatchlogs.InputLogEvent
+	closed bool
+	wake   chan struct{}
+}
+
+func newMessageQueue() *messageQueue {
+	return &messageQueue{wake: make(chan struct{}, 1)}
+}
+
+func (q *messageQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue returns false, without enqueuing, once the queue has been closed.
+func (q *messageQueue) Enqueue(e *cloudwatchlogs.InputLogEvent) bool {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return false
+	}
+	q.items = append(q.items, e)
+	q.mu.Unlock()
+
+	q.signal()
+	return true
+}
+
+func (q *messageQueue) Drain() ([]*cloudwatchlogs.InputLogEvent, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := q.items
+	q.items = nil
+	return items, q.closed
+}
+
+func (q *messageQueue) Wake() <-chan struct{} {
+	return q.wake
+}
+
+func (q *messageQueue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+
+	q.signal()
+}
@@ -94,27 +94,36 @@ func (sc *ScannerWriter) flush() error {
 		return WriterClosedError
 	}
 
-	if len(sc.buf) == 0 {
-		sc.buf = nil
-		return nil
-	}
+	data := sc.buf
+	sc.buf = nil
 
-	_, token, err := sc.splitFunc(sc.buf, true)
-	if err != nil {
-		if err == io.EOF {
-			return nil
-		}
-		return err
-	}
-	if len(token) > 0 {
-		if err := sc.tokenFunc(token); err != nil {
+	// Drain the split func until it has nothing left to emit. This is more
+	// than a single call for split funcs (like the multiline one) that hold
+	// state across calls: the first call may only absorb data rather than
+	// emit a token for it.
+	for {
+		adv, token, err := sc.splitFunc(data, true)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
 			return err
 		}
-	}
 
-	sc.buf = nil
+		if len(token) > 0 {
+			if err := sc.tokenFunc(token); err != nil {
+				return err
+			}
+		}
 
-	return nil
+		if adv > 0 {
+			data = data[adv:]
+		}
+
+		if adv == 0 && token == nil {
+			return nil
+		}
+	}
 
 }
 